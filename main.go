@@ -5,10 +5,12 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
 	"time"
 
 	"example.com/drone-secret-1password/plugin"
+	"example.com/drone-secret-1password/plugin/metrics"
 	"github.com/drone/drone-go/plugin/secret"
 
 	_ "github.com/joho/godotenv/autoload"
@@ -23,6 +25,23 @@ type spec struct {
 	ConnectHost    string        `envconfig:"OP_CONNECT_HOST"`
 	ConnectToken   string        `envconfig:"OP_CONNECT_TOKEN"`
 	ConnectTimeout time.Duration `envconfig:"OP_CONNECT_TIMEOUT" default:"15s"`
+	CacheTTL       time.Duration `envconfig:"OP_CACHE_TTL" default:"5m"`
+	MaxRetries     int           `envconfig:"OP_MAX_RETRIES" default:"3"`
+	RetryInitial   time.Duration `envconfig:"OP_RETRY_INITIAL" default:"200ms"`
+	RetryMax       time.Duration `envconfig:"OP_RETRY_MAX" default:"5s"`
+	RequestTimeout time.Duration `envconfig:"OP_REQUEST_TIMEOUT" default:"10s"`
+
+	TokenSource      string `envconfig:"OP_TOKEN_SOURCE" default:"static"`
+	K8sTokenPath     string `envconfig:"OP_K8S_TOKEN_PATH"`
+	OIDCTokenURL     string `envconfig:"OP_OIDC_TOKEN_URL"`
+	OIDCClientID     string `envconfig:"OP_OIDC_CLIENT_ID"`
+	OIDCClientSecret string `envconfig:"OP_OIDC_CLIENT_SECRET"`
+	OIDCScope        string `envconfig:"OP_OIDC_SCOPE"`
+
+	// DisableMetrics skips registering and mounting the /metrics handler,
+	// for operators in restricted environments who don't run a
+	// Prometheus scraper.
+	DisableMetrics bool `envconfig:"OP_DISABLE_METRICS"`
 }
 
 func main() {
@@ -42,9 +61,6 @@ func main() {
 	if spec.ConnectHost == "" {
 		logger.Fatalln("missing OP_CONNECT_HOST")
 	}
-	if spec.ConnectToken == "" {
-		logger.Fatalln("missing OP_CONNECT_TOKEN")
-	}
 	if spec.Bind == "" {
 		spec.Bind = ":3000"
 	}
@@ -53,11 +69,27 @@ func main() {
 	}
 
 	client := &http.Client{Timeout: spec.ConnectTimeout}
+	tokenSource, err := newTokenSource(spec, client)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	var collectors *metrics.Collectors
+	if !spec.DisableMetrics {
+		collectors = metrics.New()
+	}
+
 	plug, err := plugin.New(plugin.Config{
-		BaseURL:    spec.ConnectHost,
-		Token:      spec.ConnectToken,
-		HTTPClient: client,
-		Logger:     logger,
+		BaseURL:        spec.ConnectHost,
+		TokenSource:    tokenSource,
+		HTTPClient:     client,
+		Logger:         logger,
+		CacheTTL:       spec.CacheTTL,
+		MaxRetries:     spec.MaxRetries,
+		RetryInitial:   spec.RetryInitial,
+		RetryMax:       spec.RetryMax,
+		RequestTimeout: spec.RequestTimeout,
+		Metrics:        collectors,
 	})
 	if err != nil {
 		logger.Fatal(err)
@@ -72,5 +104,29 @@ func main() {
 	logger.Infof("server listening on address %s", spec.Bind)
 
 	http.Handle("/", handler)
+	if collectors != nil {
+		http.Handle("/metrics", collectors.Handler())
+	}
 	logger.Fatal(http.ListenAndServe(spec.Bind, nil))
 }
+
+// newTokenSource builds the plugin.TokenSource selected by OP_TOKEN_SOURCE,
+// validating the provider-specific settings it needs.
+func newTokenSource(spec *spec, httpClient *http.Client) (plugin.TokenSource, error) {
+	switch spec.TokenSource {
+	case "", "static":
+		if spec.ConnectToken == "" {
+			return nil, fmt.Errorf("missing OP_CONNECT_TOKEN")
+		}
+		return plugin.NewStaticTokenSource(spec.ConnectToken), nil
+	case "k8s":
+		return plugin.NewK8sTokenSource(spec.K8sTokenPath), nil
+	case "oidc":
+		if spec.OIDCTokenURL == "" || spec.OIDCClientID == "" || spec.OIDCClientSecret == "" {
+			return nil, fmt.Errorf("OP_OIDC_TOKEN_URL, OP_OIDC_CLIENT_ID and OP_OIDC_CLIENT_SECRET are required for OP_TOKEN_SOURCE=oidc")
+		}
+		return plugin.NewOIDCTokenSource(spec.OIDCTokenURL, spec.OIDCClientID, spec.OIDCClientSecret, spec.OIDCScope, httpClient), nil
+	default:
+		return nil, fmt.Errorf("unsupported OP_TOKEN_SOURCE %q", spec.TokenSource)
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2019 the Drone Authors. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package plugin
+
+import (
+	"context"
+	"strings"
+)
+
+// Backend resolves a single secret reference. ref is the portion of the
+// secret path after the "scheme://" prefix, e.g. for "env://DB_PASSWORD"
+// ref is "DB_PASSWORD".
+type Backend interface {
+	Resolve(ctx context.Context, ref string) (string, error)
+}
+
+// backendRegistry dispatches a secret path to the Backend registered for
+// its scheme.
+type backendRegistry map[string]Backend
+
+// defaultScheme is assumed for secret paths that carry no "scheme://"
+// prefix, preserving the original vault/item/field behavior.
+const defaultScheme = "op"
+
+// splitSecretScheme splits a secret path into its scheme and the remainder
+// of the reference. Paths without a "scheme://" prefix return an empty
+// scheme so callers can fall back to defaultScheme.
+func splitSecretScheme(path string) (scheme, ref string) {
+	const sep = "://"
+	idx := strings.Index(path, sep)
+	if idx < 0 {
+		return "", path
+	}
+	return path[:idx], path[idx+len(sep):]
+}
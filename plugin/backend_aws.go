@@ -0,0 +1,20 @@
+// Copyright 2019 the Drone Authors. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+)
+
+// awsBackend is registered for aws:// references (e.g.
+// aws://region/name#key). It does not contact AWS Secrets Manager: every
+// call returns an error naming the requested reference, so aws:// paths
+// fail with that message rather than "unsupported secret backend".
+type awsBackend struct{}
+
+func (awsBackend) Resolve(_ context.Context, ref string) (string, error) {
+	return "", fmt.Errorf("aws backend is not implemented yet (requested %q)", ref)
+}
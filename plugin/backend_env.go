@@ -0,0 +1,27 @@
+// Copyright 2019 the Drone Authors. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// envBackend resolves secrets from the Drone server's own process
+// environment, e.g. env://DATABASE_PASSWORD. It lets operators migrate a
+// secret off 1Password one reference at a time.
+type envBackend struct{}
+
+func (envBackend) Resolve(_ context.Context, ref string) (string, error) {
+	if ref == "" {
+		return "", fmt.Errorf("env secret reference must name a variable")
+	}
+	value, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", fmt.Errorf("environment variable %q is not set", ref)
+	}
+	return value, nil
+}
@@ -0,0 +1,27 @@
+// Copyright 2019 the Drone Authors. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// fileBackend resolves secrets from files mounted on the Drone server,
+// e.g. file:///run/secrets/db_password.
+type fileBackend struct{}
+
+func (fileBackend) Resolve(_ context.Context, ref string) (string, error) {
+	if ref == "" {
+		return "", fmt.Errorf("file secret reference must name a path")
+	}
+	data, err := os.ReadFile(ref)
+	if err != nil {
+		return "", fmt.Errorf("read secret file %q: %w", ref, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2019 the Drone Authors. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// opBackend resolves secrets from a 1Password Connect server. It backs the
+// default (schemeless) secret path as well as explicit op:// references,
+// both formatted as vault/item[/field].
+type opBackend struct {
+	client *connectClient
+}
+
+func newOPBackend(client *connectClient) *opBackend {
+	return &opBackend{client: client}
+}
+
+func (b *opBackend) Resolve(ctx context.Context, ref string) (string, error) {
+	if vaultID, itemID, field, ok := parseNativeItemRef(ref); ok {
+		item, err := b.client.getItem(ctx, vaultID, itemID)
+		if err != nil {
+			return "", fmt.Errorf("load item %q: %w", itemID, err)
+		}
+		return selectFieldValue(item, field)
+	}
+
+	vaultName, itemTitle, fieldSelector, err := parseSecretPath(ref)
+	if err != nil {
+		return "", err
+	}
+
+	vault, err := b.client.findVaultByName(ctx, vaultName)
+	if err != nil {
+		return "", fmt.Errorf("lookup vault %q: %w", vaultName, err)
+	}
+	itemSummary, err := b.client.findItemByTitle(ctx, vault.ID, itemTitle)
+	if err != nil {
+		if isNotFound(err) {
+			b.client.invalidateVault(vaultName)
+		}
+		return "", fmt.Errorf("lookup item %q: %w", itemTitle, err)
+	}
+	item, err := b.client.getItem(ctx, vault.ID, itemSummary.ID)
+	if err != nil {
+		if isNotFound(err) {
+			b.client.invalidateItem(vault.ID, itemTitle)
+		}
+		return "", fmt.Errorf("load item %q: %w", itemTitle, err)
+	}
+
+	return selectFieldValue(item, fieldSelector)
+}
+
+// parseNativeItemRef recognizes 1Password's native "vaults/<uuid>/items/<uuid>"
+// path shape, optionally followed by a field selector, and lets callers skip
+// the vault/item filter lookups entirely when the caller already knows the
+// IDs.
+func parseNativeItemRef(ref string) (vaultID, itemID, field string, ok bool) {
+	parts := strings.SplitN(ref, "/", 5)
+	if len(parts) < 4 || parts[0] != "vaults" || parts[2] != "items" {
+		return "", "", "", false
+	}
+	vaultID, itemID = parts[1], parts[3]
+	if vaultID == "" || itemID == "" {
+		return "", "", "", false
+	}
+	if len(parts) == 5 {
+		field = parts[4]
+	}
+	return vaultID, itemID, field, true
+}
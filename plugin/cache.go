@@ -0,0 +1,114 @@
+// Copyright 2019 the Drone Authors. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package plugin
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// cacheMaxEntries bounds how many vault/item IDs a ttlCache holds before it
+// starts evicting the least recently used entry.
+const cacheMaxEntries = 512
+
+// ttlCache is a small, size-bounded, least-recently-used cache with
+// per-entry expiry. It is used to avoid repeating 1Password Connect
+// vault/item filter lookups for every secret request.
+type ttlCache struct {
+	ttl   time.Duration
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+
+	hits   uint64
+	misses uint64
+}
+
+type cacheEntry struct {
+	key     string
+	value   string
+	expires time.Time
+}
+
+// newTTLCache builds a cache that never stores anything when ttl <= 0,
+// effectively turning get/set into no-ops.
+func newTTLCache(ttl time.Duration) *ttlCache {
+	return &ttlCache{
+		ttl:   ttl,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (c *ttlCache) get(key string) (string, bool) {
+	if c.ttl <= 0 {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		atomic.AddUint64(&c.misses, 1)
+		return "", false
+	}
+	entry := el.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.ll.Remove(el)
+		delete(c.items, key)
+		atomic.AddUint64(&c.misses, 1)
+		return "", false
+	}
+	c.ll.MoveToFront(el)
+	atomic.AddUint64(&c.hits, 1)
+	return entry.value, true
+}
+
+func (c *ttlCache) set(key, value string) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		el.Value.(*cacheEntry).value = value
+		el.Value.(*cacheEntry).expires = time.Now().Add(c.ttl)
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	el := c.ll.PushFront(&cacheEntry{key: key, value: value, expires: time.Now().Add(c.ttl)})
+	c.items[key] = el
+
+	for c.ll.Len() > cacheMaxEntries {
+		oldest := c.ll.Back()
+		if oldest == nil {
+			break
+		}
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+func (c *ttlCache) delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return
+	}
+	c.ll.Remove(el)
+	delete(c.items, key)
+}
+
+// stats returns the cumulative hit/miss counts, for callers exposing cache
+// effectiveness as metrics.
+func (c *ttlCache) stats() (hits, misses uint64) {
+	return atomic.LoadUint64(&c.hits), atomic.LoadUint64(&c.misses)
+}
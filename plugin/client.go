@@ -3,27 +3,76 @@ package plugin
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"example.com/drone-secret-1password/plugin/metrics"
 )
 
+// connectClientConfig collects the tunables newConnectClient needs. It
+// exists (rather than a long parameter list) because it has grown with
+// each feature added to the Connect client and is expected to keep
+// growing.
+type connectClientConfig struct {
+	BaseURL string
+	// Token is used to build a static TokenSource when TokenSource is
+	// nil; most callers only need this.
+	Token       string
+	TokenSource TokenSource
+	HTTPClient  *http.Client
+	CacheTTL    time.Duration
+
+	MaxRetries   int
+	RetryInitial time.Duration
+	RetryMax     time.Duration
+
+	// RequestTimeout bounds each individual Connect call. Zero means no
+	// additional deadline beyond the HTTPClient's own timeout and
+	// whatever the caller's context already carries.
+	RequestTimeout time.Duration
+
+	// Metrics receives latency and cache hit ratio observations. A nil
+	// value disables metrics.
+	Metrics *metrics.Collectors
+}
+
 type connectClient struct {
-	baseURL    *url.URL
-	httpClient *http.Client
-	token      string
+	baseURL     *url.URL
+	httpClient  *http.Client
+	tokenSource *refreshingTokenSource
+
+	vaultCache *ttlCache
+	itemCache  *ttlCache
+
+	retry retryPolicy
+	sf    singleflight.Group
+
+	metrics *metrics.Collectors
+
+	deadlineMu     sync.RWMutex
+	requestTimeout time.Duration
 }
 
-func newConnectClient(baseURL, token string, httpClient *http.Client) (*connectClient, error) {
-	if baseURL == "" {
+func newConnectClient(cfg connectClientConfig) (*connectClient, error) {
+	if cfg.BaseURL == "" {
 		return nil, fmt.Errorf("missing 1Password Connect host")
 	}
-	if token == "" {
-		return nil, fmt.Errorf("missing 1Password Connect token")
+	tokenSource := cfg.TokenSource
+	if tokenSource == nil {
+		if cfg.Token == "" {
+			return nil, fmt.Errorf("missing 1Password Connect token")
+		}
+		tokenSource = staticTokenSource{token: cfg.Token}
 	}
-	parsed, err := url.Parse(baseURL)
+	parsed, err := url.Parse(cfg.BaseURL)
 	if err != nil {
 		return nil, fmt.Errorf("invalid 1Password Connect host: %w", err)
 	}
@@ -40,63 +89,177 @@ func newConnectClient(baseURL, token string, httpClient *http.Client) (*connectC
 	parsed.RawQuery = ""
 	parsed.Fragment = ""
 
-	client := httpClient
+	client := cfg.HTTPClient
 	if client == nil {
 		client = &http.Client{Timeout: 15 * time.Second}
 	}
 
+	retry := retryPolicy{
+		MaxRetries: cfg.MaxRetries,
+		Initial:    cfg.RetryInitial,
+		Max:        cfg.RetryMax,
+	}
+	if retry.Initial <= 0 {
+		retry.Initial = 200 * time.Millisecond
+	}
+	if retry.Max <= 0 {
+		retry.Max = 5 * time.Second
+	}
+
 	return &connectClient{
-		baseURL:    parsed,
-		httpClient: client,
-		token:      token,
+		baseURL:        parsed,
+		httpClient:     client,
+		tokenSource:    newRefreshingTokenSource(tokenSource),
+		vaultCache:     newTTLCache(cfg.CacheTTL),
+		itemCache:      newTTLCache(cfg.CacheTTL),
+		retry:          retry,
+		metrics:        cfg.Metrics,
+		requestTimeout: cfg.RequestTimeout,
 	}, nil
 }
 
+// SetDeadline updates the per-request timeout applied to future Connect
+// calls, overriding the value derived from OP_REQUEST_TIMEOUT. Passing 0
+// disables the deadline. It is safe to call concurrently with in-flight
+// requests.
+func (c *connectClient) SetDeadline(d time.Duration) {
+	c.deadlineMu.Lock()
+	c.requestTimeout = d
+	c.deadlineMu.Unlock()
+}
+
+// withRequestDeadline derives a context bounded by the configured
+// RequestTimeout, if any, on top of whatever deadline/cancellation ctx
+// already carries (e.g. from the Drone server closing the handler
+// connection).
+func (c *connectClient) withRequestDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	c.deadlineMu.RLock()
+	timeout := c.requestTimeout
+	c.deadlineMu.RUnlock()
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
 func (c *connectClient) findVaultByName(ctx context.Context, name string) (*vaultSummary, error) {
+	if id, ok := c.vaultCache.get(name); ok {
+		c.reportCacheRatio("vault")
+		return &vaultSummary{ID: id, Name: name}, nil
+	}
+	c.reportCacheRatio("vault")
+
+	ctx, cancel := c.withRequestDeadline(ctx)
+	defer cancel()
+
 	var vaults []vaultSummary
-	err := c.get(ctx, "vaults", url.Values{
+	err := c.get(ctx, "find_vault", "vaults", url.Values{
 		"filter": {buildEqualsFilter("name", name)},
 	}, &vaults)
 	if err != nil {
 		return nil, err
 	}
 	if len(vaults) == 0 {
-		return nil, fmt.Errorf("vault %q not found", name)
+		return nil, fmt.Errorf("%w: %q", ErrVaultNotFound, name)
 	}
 	if len(vaults) > 1 {
-		return nil, fmt.Errorf("multiple vaults match name %q", name)
+		return nil, fmt.Errorf("%w: %q", ErrVaultAmbiguous, name)
 	}
+	c.vaultCache.set(name, vaults[0].ID)
 	return &vaults[0], nil
 }
 
+// reportCacheRatio pushes a cache's cumulative hit ratio to the metrics
+// collector, keyed by cache name ("vault" or "item").
+func (c *connectClient) reportCacheRatio(cache string) {
+	var hits, misses uint64
+	switch cache {
+	case "vault":
+		hits, misses = c.vaultCache.stats()
+	case "item":
+		hits, misses = c.itemCache.stats()
+	}
+	c.metrics.SetCacheHitRatio(cache, hits, misses)
+}
+
+func (c *connectClient) invalidateVault(name string) {
+	c.vaultCache.delete(name)
+}
+
+func itemCacheKey(vaultID, title string) string {
+	return vaultID + "\x00" + title
+}
+
 func (c *connectClient) findItemByTitle(ctx context.Context, vaultID, title string) (*itemSummary, error) {
+	cacheKey := itemCacheKey(vaultID, title)
+	if id, ok := c.itemCache.get(cacheKey); ok {
+		c.reportCacheRatio("item")
+		return &itemSummary{ID: id, Title: title}, nil
+	}
+	c.reportCacheRatio("item")
+
+	ctx, cancel := c.withRequestDeadline(ctx)
+	defer cancel()
+
 	var items []itemSummary
 	path := fmt.Sprintf("vaults/%s/items", escapePathSegment(vaultID))
-	err := c.get(ctx, path, url.Values{
+	err := c.get(ctx, "find_item", path, url.Values{
 		"filter": {buildEqualsFilter("title", title)},
 	}, &items)
 	if err != nil {
 		return nil, err
 	}
 	if len(items) == 0 {
-		return nil, fmt.Errorf("item %q not found in vault %q", title, vaultID)
+		return nil, fmt.Errorf("%w: %q in vault %q", ErrItemNotFound, title, vaultID)
 	}
 	if len(items) > 1 {
-		return nil, fmt.Errorf("multiple items named %q found in vault %q", title, vaultID)
+		return nil, fmt.Errorf("%w: %q in vault %q", ErrItemAmbiguous, title, vaultID)
 	}
+	c.itemCache.set(cacheKey, items[0].ID)
 	return &items[0], nil
 }
 
+func (c *connectClient) invalidateItem(vaultID, title string) {
+	c.itemCache.delete(itemCacheKey(vaultID, title))
+}
+
 func (c *connectClient) getItem(ctx context.Context, vaultID, itemID string) (*fullItem, error) {
+	ctx, cancel := c.withRequestDeadline(ctx)
+	defer cancel()
+
 	var item fullItem
 	path := fmt.Sprintf("vaults/%s/items/%s", escapePathSegment(vaultID), escapePathSegment(itemID))
-	if err := c.get(ctx, path, nil, &item); err != nil {
+	if err := c.get(ctx, "get_item", path, nil, &item); err != nil {
+		if isNotFound(err) {
+			return nil, fmt.Errorf("%w: %w", ErrItemNotFound, err)
+		}
 		return nil, err
 	}
 	return &item, nil
 }
 
-func (c *connectClient) get(ctx context.Context, path string, query url.Values, out interface{}) error {
+// get issues a GET request for the Connect API and decodes its JSON
+// response into out. operation labels the request's Connect API latency
+// metric and should identify the call site (e.g. "find_vault"), not the
+// dynamic path.
+func (c *connectClient) get(ctx context.Context, operation, path string, query url.Values, out interface{}) error {
+	u := c.resolveURL(path, query)
+
+	started := time.Now()
+	body, err, _ := c.sf.Do(u, func() (interface{}, error) {
+		return c.getWithRetry(ctx, u)
+	})
+	c.metrics.ObserveConnectLatency(operation, time.Since(started))
+	if err != nil {
+		return err
+	}
+	if out == nil || body == nil {
+		return nil
+	}
+	return json.Unmarshal(body.([]byte), out)
+}
+
+func (c *connectClient) resolveURL(path string, query url.Values) string {
 	u := *c.baseURL
 	basePath := strings.TrimSuffix(c.baseURL.Path, "/")
 	relative := strings.TrimPrefix(path, "/")
@@ -108,27 +271,62 @@ func (c *connectClient) get(ctx context.Context, path string, query url.Values,
 	if len(query) > 0 {
 		u.RawQuery = query.Encode()
 	}
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	return u.String()
+}
+
+// getWithRetry issues a GET request, retrying RetryableErrors (429/5xx)
+// with exponential backoff and jitter, honoring any Retry-After the server
+// sent. Requests coalesced by singleflight share a single retry loop.
+func (c *connectClient) getWithRetry(ctx context.Context, url string) ([]byte, error) {
+	var lastErr error
+	for attempt := 0; attempt <= c.retry.MaxRetries; attempt++ {
+		body, err := c.doOnce(ctx, url)
+		if err == nil {
+			return body, nil
+		}
+		lastErr = err
+
+		var retryable *RetryableError
+		if !errors.As(err, &retryable) || attempt == c.retry.MaxRetries {
+			return nil, err
+		}
+		wait := retryable.RetryAfter
+		if wait <= 0 {
+			wait = c.retry.nextBackoff(attempt)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+	return nil, lastErr
+}
+
+func (c *connectClient) doOnce(ctx context.Context, url string) ([]byte, error) {
+	token, err := c.tokenSource.Token(ctx)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("%w: %s", ErrUnauthorized, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
 	}
-	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Authorization", "Bearer "+token)
 	req.Header.Set("Accept", "application/json")
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-		if out == nil {
-			return nil
-		}
-		return json.NewDecoder(resp.Body).Decode(out)
+		return io.ReadAll(resp.Body)
 	}
 
-	apiErr := &apiError{StatusCode: resp.StatusCode, Message: resp.Status}
+	apiErr := &APIError{StatusCode: resp.StatusCode, Message: resp.Status}
 	var payload struct {
 		Status  int    `json:"status"`
 		Message string `json:"message"`
@@ -136,18 +334,34 @@ func (c *connectClient) get(ctx context.Context, path string, query url.Values,
 	if err := json.NewDecoder(resp.Body).Decode(&payload); err == nil && payload.Message != "" {
 		apiErr.Message = payload.Message
 	}
-	return apiErr
+	if apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden {
+		return nil, fmt.Errorf("%w: %w", ErrUnauthorized, apiErr)
+	}
+	if apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500 {
+		return nil, &RetryableError{Err: apiErr, RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+	return nil, apiErr
 }
 
-type apiError struct {
+// APIError is returned for any non-2xx response from 1Password Connect
+// that isn't otherwise classified (unauthorized, retryable).
+type APIError struct {
 	StatusCode int
 	Message    string
 }
 
-func (e *apiError) Error() string {
+func (e *APIError) Error() string {
 	return fmt.Sprintf("1Password Connect error (%d): %s", e.StatusCode, e.Message)
 }
 
+// isNotFound reports whether err is an *APIError for an HTTP 404, used to
+// invalidate stale cache entries for vaults/items that have since been
+// deleted or renamed.
+func isNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
 func buildEqualsFilter(field, value string) string {
 	return fmt.Sprintf(`%s eq "%s"`, field, escapeFilterValue(value))
 }
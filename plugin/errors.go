@@ -0,0 +1,21 @@
+// Copyright 2019 the Drone Authors. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package plugin
+
+import "errors"
+
+// Sentinel errors returned by the op backend and the Connect client.
+// Callers can match them with errors.Is, even through the contextual
+// wrapping (vault/item/field name, underlying *APIError) added at each
+// call site.
+var (
+	ErrVaultNotFound  = errors.New("vault not found")
+	ErrVaultAmbiguous = errors.New("multiple vaults match")
+	ErrItemNotFound   = errors.New("item not found")
+	ErrItemAmbiguous  = errors.New("multiple items match")
+	ErrFieldNotFound  = errors.New("field not found")
+	ErrFieldAmbiguous = errors.New("field selector is ambiguous")
+	ErrUnauthorized   = errors.New("unauthorized")
+)
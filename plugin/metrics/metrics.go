@@ -0,0 +1,116 @@
+// Copyright 2019 the Drone Authors. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+// Package metrics exposes the plugin's Prometheus collectors. The recording
+// methods are nil-receiver safe, so callers can pass around a nil
+// *Collectors (e.g. when metrics are disabled) without guarding every call
+// site; Handler is not, and needs a non-nil *Collectors.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Collectors holds the Prometheus collectors the plugin reports. They are
+// registered against a dedicated registry, rather than the global default,
+// so multiple instances (e.g. in tests) don't collide.
+type Collectors struct {
+	registry *prometheus.Registry
+
+	requestsTotal  *prometheus.CounterVec
+	connectLatency *prometheus.HistogramVec
+	cacheHitRatio  *prometheus.GaugeVec
+	inFlight       prometheus.Gauge
+}
+
+// New registers and returns the plugin's Prometheus collectors.
+func New() *Collectors {
+	registry := prometheus.NewRegistry()
+	c := &Collectors{
+		registry: registry,
+		requestsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "drone_1password",
+			Name:      "secret_requests_total",
+			Help:      "Total secret resolution requests, labeled by vault, result (success/failure) and error class.",
+		}, []string{"vault", "result", "error_class"}),
+		connectLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "drone_1password",
+			Name:      "connect_request_duration_seconds",
+			Help:      "Latency of requests to the 1Password Connect API, labeled by operation.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"operation"}),
+		cacheHitRatio: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "drone_1password",
+			Name:      "cache_hit_ratio",
+			Help:      "Cumulative hit ratio of the vault/item lookup caches, labeled by cache name.",
+		}, []string{"cache"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "drone_1password",
+			Name:      "secret_requests_in_flight",
+			Help:      "Number of secret resolution requests currently being handled.",
+		}),
+	}
+	registry.MustRegister(c.requestsTotal, c.connectLatency, c.cacheHitRatio, c.inFlight)
+	return c
+}
+
+// Handler returns the http.Handler that serves these Collectors in the
+// Prometheus exposition format, for mounting at "/metrics".
+func (c *Collectors) Handler() http.Handler {
+	return promhttp.HandlerFor(c.registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest records the outcome of a single secret resolution. vault
+// is the best-effort vault name (empty for backends that have none).
+// errorClass is recorded as given; the plugin passes an empty one when
+// result is "success".
+func (c *Collectors) ObserveRequest(vault, result, errorClass string) {
+	if c == nil {
+		return
+	}
+	c.requestsTotal.WithLabelValues(vault, result, errorClass).Inc()
+}
+
+// ObserveConnectLatency records how long a named Connect API operation
+// took to complete, including any time spent waiting on a coalesced
+// singleflight request.
+func (c *Collectors) ObserveConnectLatency(operation string, d time.Duration) {
+	if c == nil {
+		return
+	}
+	c.connectLatency.WithLabelValues(operation).Observe(d.Seconds())
+}
+
+// SetCacheHitRatio reports the cumulative hit ratio for a named cache. It
+// is a no-op until at least one lookup has been made.
+func (c *Collectors) SetCacheHitRatio(cache string, hits, misses uint64) {
+	if c == nil {
+		return
+	}
+	total := hits + misses
+	if total == 0 {
+		return
+	}
+	c.cacheHitRatio.WithLabelValues(cache).Set(float64(hits) / float64(total))
+}
+
+// IncInFlight and DecInFlight track the number of secret resolution
+// requests currently being handled.
+func (c *Collectors) IncInFlight() {
+	if c == nil {
+		return
+	}
+	c.inFlight.Inc()
+}
+
+func (c *Collectors) DecInFlight() {
+	if c == nil {
+		return
+	}
+	c.inFlight.Dec()
+}
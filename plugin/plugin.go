@@ -10,26 +10,70 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/drone/drone-go/drone"
 	"github.com/drone/drone-go/plugin/secret"
 	"github.com/sirupsen/logrus"
+	"go.uber.org/multierr"
+
+	"example.com/drone-secret-1password/plugin/metrics"
 )
 
 type Config struct {
-	BaseURL    string
-	Token      string
-	HTTPClient *http.Client
-	Logger     logrus.FieldLogger
+	BaseURL string
+	// Token authenticates to 1Password Connect and is used when
+	// TokenSource is nil. Most callers only need this.
+	Token       string
+	TokenSource TokenSource
+	HTTPClient  *http.Client
+	Logger      logrus.FieldLogger
+
+	// CacheTTL controls how long resolved vault and item IDs are cached.
+	// A zero value disables caching.
+	CacheTTL time.Duration
+
+	// MaxRetries, RetryInitial and RetryMax control the backoff applied to
+	// Connect requests that fail with a retryable (429/5xx) response.
+	// Zero RetryInitial and RetryMax fall back to 200ms and 5s; a zero
+	// MaxRetries disables retrying.
+	MaxRetries   int
+	RetryInitial time.Duration
+	RetryMax     time.Duration
+
+	// RequestTimeout bounds each individual Connect call, in addition to
+	// whatever deadline the inbound secret.Request's context carries.
+	RequestTimeout time.Duration
+
+	// Backends registers additional vault backends, or overrides one of
+	// the built-ins ("op", "env", "file", "aws"), keyed by the scheme
+	// used in secret paths (e.g. "op" for "op://Vault/Item/field").
+	Backends map[string]Backend
+
+	// Metrics receives Prometheus observations for secret requests and
+	// Connect API calls. A nil value (the default) disables metrics.
+	Metrics *metrics.Collectors
 }
 
 type plugin struct {
-	client *connectClient
-	logger logrus.FieldLogger
+	backends backendRegistry
+	logger   logrus.FieldLogger
+	metrics  *metrics.Collectors
 }
 
 func New(cfg Config) (secret.Plugin, error) {
-	client, err := newConnectClient(cfg.BaseURL, cfg.Token, cfg.HTTPClient)
+	client, err := newConnectClient(connectClientConfig{
+		BaseURL:        cfg.BaseURL,
+		Token:          cfg.Token,
+		TokenSource:    cfg.TokenSource,
+		HTTPClient:     cfg.HTTPClient,
+		CacheTTL:       cfg.CacheTTL,
+		MaxRetries:     cfg.MaxRetries,
+		RetryInitial:   cfg.RetryInitial,
+		RetryMax:       cfg.RetryMax,
+		RequestTimeout: cfg.RequestTimeout,
+		Metrics:        cfg.Metrics,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -37,9 +81,21 @@ func New(cfg Config) (secret.Plugin, error) {
 	if logger == nil {
 		logger = logrus.New()
 	}
+
+	backends := backendRegistry{
+		defaultScheme: newOPBackend(client),
+		"env":         envBackend{},
+		"file":        fileBackend{},
+		"aws":         awsBackend{},
+	}
+	for scheme, backend := range cfg.Backends {
+		backends[scheme] = backend
+	}
+
 	return &plugin{
-		client: client,
-		logger: logger,
+		backends: backends,
+		logger:   logger,
+		metrics:  cfg.Metrics,
 	}, nil
 }
 
@@ -48,52 +104,52 @@ func (p *plugin) Find(ctx context.Context, req *secret.Request) (*drone.Secret,
 		p.logger.Error("secret request failed: nil request")
 		return nil, errors.New("nil request")
 	}
+
+	p.metrics.IncInFlight()
+	defer p.metrics.DecInFlight()
+
+	scheme, ref := splitSecretScheme(req.Path)
+	if scheme == "" {
+		scheme = defaultScheme
+	}
+	vault, item, field := describeRef(scheme, ref)
+
+	// This entry doubles as the audit trail: every outcome below logs
+	// through it, carrying the repo/build that requested the secret
+	// alongside the vault/item/field it resolved to.
 	entry := p.logger.WithFields(logrus.Fields{
 		"secret": req.Name,
 		"path":   req.Path,
+		"repo":   req.Repo.Slug,
+		"build":  req.Build.Number,
+		"vault":  vault,
+		"item":   item,
+		"field":  field,
 	})
 	entry.Info("secret request received")
 	if req.Name == "" {
 		err := errors.New("secret name must not be empty")
-		entry.WithError(err).Error("secret request failed")
+		entry.WithError(err).WithField("result", "failure").Error("secret request failed")
+		p.metrics.ObserveRequest(vault, "failure", "invalid_request")
 		return nil, err
 	}
-	vaultName, itemTitle, fieldSelector, err := parseSecretPath(req.Path)
-	if err != nil {
-		entry.WithError(err).Error("secret request failed")
+	backend, ok := p.backends[scheme]
+	if !ok {
+		err := fmt.Errorf("unsupported secret backend %q", scheme)
+		entry.WithError(err).WithField("result", "failure").Error("secret request failed")
+		p.metrics.ObserveRequest(vault, "failure", "unsupported_backend")
 		return nil, err
 	}
 
-	vault, err := p.client.findVaultByName(ctx, vaultName)
-	if err != nil {
-		err = fmt.Errorf("lookup vault %q: %w", vaultName, err)
-		entry.WithError(err).Error("secret request failed")
-		return nil, err
-	}
-	itemSummary, err := p.client.findItemByTitle(ctx, vault.ID, itemTitle)
+	value, err := backend.Resolve(ctx, ref)
 	if err != nil {
-		err = fmt.Errorf("lookup item %q: %w", itemTitle, err)
-		entry.WithError(err).Error("secret request failed")
-		return nil, err
-	}
-	item, err := p.client.getItem(ctx, vault.ID, itemSummary.ID)
-	if err != nil {
-		err = fmt.Errorf("load item %q: %w", itemTitle, err)
-		entry.WithError(err).Error("secret request failed")
+		entry.WithError(err).WithField("result", "failure").Error("secret request failed")
+		p.metrics.ObserveRequest(vault, "failure", errorClass(err))
 		return nil, err
 	}
 
-	value, err := selectFieldValue(item, fieldSelector)
-	if err != nil {
-		entry.WithError(err).Error("secret request failed")
-		return nil, err
-	}
-
-	entry.WithFields(logrus.Fields{
-		"vault": vault.Name,
-		"item":  item.Title,
-		"field": fieldSelector,
-	}).Info("secret request succeeded")
+	entry.WithFields(logrus.Fields{"backend": scheme, "result": "success"}).Info("secret request succeeded")
+	p.metrics.ObserveRequest(vault, "success", "")
 
 	return &drone.Secret{
 		Name:        req.Name,
@@ -102,6 +158,43 @@ func (p *plugin) Find(ctx context.Context, req *secret.Request) (*drone.Secret,
 	}, nil
 }
 
+// describeRef extracts the vault/item/field a secret reference resolves
+// to, for audit logging and metrics labeling. Only the "op" backend's refs
+// have this shape; for any other scheme all three are empty and the raw
+// path already logged by the caller is the only reference recorded.
+func describeRef(scheme, ref string) (vault, item, field string) {
+	if scheme != defaultScheme {
+		return "", "", ""
+	}
+	if v, i, f, ok := parseNativeItemRef(ref); ok {
+		return v, i, f
+	}
+	v, i, f, err := parseSecretPath(ref)
+	if err != nil {
+		return "", "", ""
+	}
+	return v, i, f
+}
+
+// errorClass buckets an error into the small, bounded set of labels
+// secret_requests_total's error_class uses, so the metric's cardinality
+// doesn't grow with dynamic vault/item names.
+func errorClass(err error) string {
+	switch {
+	case errors.Is(err, ErrVaultNotFound), errors.Is(err, ErrItemNotFound), errors.Is(err, ErrFieldNotFound):
+		return "not_found"
+	case errors.Is(err, ErrVaultAmbiguous), errors.Is(err, ErrItemAmbiguous), errors.Is(err, ErrFieldAmbiguous):
+		return "ambiguous"
+	case errors.Is(err, ErrUnauthorized):
+		return "unauthorized"
+	}
+	var retryable *RetryableError
+	if errors.As(err, &retryable) {
+		return "retryable"
+	}
+	return "other"
+}
+
 func parseSecretPath(path string) (vault, item, field string, err error) {
 	parts := strings.SplitN(path, "/", 3)
 	if len(parts) < 2 {
@@ -124,7 +217,7 @@ func selectFieldValue(item *fullItem, selector string) (string, error) {
 	}
 	if strings.EqualFold(selector, "notes") || strings.EqualFold(selector, "notesPlain") {
 		if item.NotesPlain == "" {
-			return "", fmt.Errorf("item %q does not contain notes", item.Title)
+			return "", fmt.Errorf("%w: notes in item %q", ErrFieldNotFound, item.Title)
 		}
 		return item.NotesPlain, nil
 	}
@@ -151,9 +244,14 @@ func defaultPassword(item *fullItem) (string, error) {
 	case 1:
 		return matches[0], nil
 	case 0:
-		return findFieldByLabel(item, "password")
+		purposeErr := fmt.Errorf("%w: no PASSWORD-purpose field in item %q", ErrFieldNotFound, item.Title)
+		value, labelErr := findFieldByLabel(item, "password")
+		if labelErr != nil {
+			return "", multierr.Append(purposeErr, labelErr)
+		}
+		return value, nil
 	default:
-		return "", fmt.Errorf("item %q defines multiple password fields; specify the desired field label", item.Title)
+		return "", fmt.Errorf("%w: item %q defines multiple password fields; specify the desired field label", ErrFieldAmbiguous, item.Title)
 	}
 }
 
@@ -172,11 +270,11 @@ func findFieldByLabel(item *fullItem, label string) (string, error) {
 	}
 	switch len(matches) {
 	case 0:
-		return "", fmt.Errorf("field %q not found in item %q", fieldLabel, item.Title)
+		return "", fmt.Errorf("%w: %q in item %q", ErrFieldNotFound, fieldLabel, item.Title)
 	case 1:
 		return matches[0].Value, nil
 	default:
-		return "", fmt.Errorf("field label %q is ambiguous in item %q; use a section-qualified label", fieldLabel, item.Title)
+		return "", fmt.Errorf("%w: label %q in item %q; use a section-qualified label", ErrFieldAmbiguous, fieldLabel, item.Title)
 	}
 }
 
@@ -189,7 +287,7 @@ func findFieldInSection(item *fullItem, sectionLabel, fieldLabel string) (string
 		}
 	}
 	if len(sectionIDs) == 0 {
-		return "", fmt.Errorf("section %q not found in item %q", sectionLabel, item.Title)
+		return "", fmt.Errorf("%w: section %q in item %q", ErrFieldNotFound, sectionLabel, item.Title)
 	}
 	var matches []itemField
 	for i := range item.Fields {
@@ -210,11 +308,11 @@ func findFieldInSection(item *fullItem, sectionLabel, fieldLabel string) (string
 	}
 	switch len(matches) {
 	case 0:
-		return "", fmt.Errorf("field %q not found in section %q", fieldLabel, sectionLabel)
+		return "", fmt.Errorf("%w: %q in section %q", ErrFieldNotFound, fieldLabel, sectionLabel)
 	case 1:
 		return matches[0].Value, nil
 	default:
-		return "", fmt.Errorf("field %q is duplicated in section %q", fieldLabel, sectionLabel)
+		return "", fmt.Errorf("%w: %q is duplicated in section %q", ErrFieldAmbiguous, fieldLabel, sectionLabel)
 	}
 }
 
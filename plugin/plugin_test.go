@@ -7,13 +7,18 @@ package plugin
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/drone/drone-go/drone"
 	"github.com/drone/drone-go/plugin/secret"
 	"github.com/sirupsen/logrus"
+
+	"example.com/drone-secret-1password/plugin/metrics"
 )
 
 func TestParseSecretPath(t *testing.T) {
@@ -135,9 +140,380 @@ func TestPluginFind(t *testing.T) {
 	}
 }
 
+func TestSplitSecretScheme(t *testing.T) {
+	tests := []struct {
+		input      string
+		wantScheme string
+		wantRef    string
+	}{
+		{"Vault/Item/Field", "", "Vault/Item/Field"},
+		{"op://Vault/Item/Field", "op", "Vault/Item/Field"},
+		{"env://DB_PASSWORD", "env", "DB_PASSWORD"},
+		{"file:///run/secrets/db_password", "file", "/run/secrets/db_password"},
+	}
+
+	for _, tc := range tests {
+		scheme, ref := splitSecretScheme(tc.input)
+		if scheme != tc.wantScheme || ref != tc.wantRef {
+			t.Fatalf("%s: got %q/%q want %q/%q", tc.input, scheme, ref, tc.wantScheme, tc.wantRef)
+		}
+	}
+}
+
+func TestPluginFindEnvBackend(t *testing.T) {
+	t.Setenv("TEST_DB_PASSWORD", "hunter2")
+
+	plug, err := New(Config{
+		BaseURL:    "https://example.com",
+		Token:      "token",
+		HTTPClient: http.DefaultClient,
+		Logger:     logrus.New(),
+	})
+	if err != nil {
+		t.Fatalf("failed to create plugin: %v", err)
+	}
+
+	secretValue, err := plug.Find(context.Background(), &secret.Request{
+		Name: "db_password",
+		Path: "env://TEST_DB_PASSWORD",
+		Repo: drone.Repo{},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if secretValue == nil || secretValue.Data != "hunter2" {
+		t.Fatalf("unexpected secret: %#v", secretValue)
+	}
+}
+
+func TestPluginFindUnsupportedBackend(t *testing.T) {
+	plug, err := New(Config{
+		BaseURL:    "https://example.com",
+		Token:      "token",
+		HTTPClient: http.DefaultClient,
+		Logger:     logrus.New(),
+	})
+	if err != nil {
+		t.Fatalf("failed to create plugin: %v", err)
+	}
+
+	_, err = plug.Find(context.Background(), &secret.Request{
+		Name: "db_password",
+		Path: "gcp://project/secret",
+	})
+	if err == nil {
+		t.Fatal("expected error for unsupported backend")
+	}
+}
+
+func TestParseNativeItemRef(t *testing.T) {
+	vaultID, itemID, field, ok := parseNativeItemRef("vaults/v-uuid/items/i-uuid")
+	if !ok || vaultID != "v-uuid" || itemID != "i-uuid" || field != "" {
+		t.Fatalf("unexpected result: %q %q %q %v", vaultID, itemID, field, ok)
+	}
+
+	vaultID, itemID, field, ok = parseNativeItemRef("vaults/v-uuid/items/i-uuid/Service Keys/Token")
+	if !ok || vaultID != "v-uuid" || itemID != "i-uuid" || field != "Service Keys/Token" {
+		t.Fatalf("unexpected result: %q %q %q %v", vaultID, itemID, field, ok)
+	}
+
+	if _, _, _, ok = parseNativeItemRef("Vault/Item/Field"); ok {
+		t.Fatal("expected non-native path to be rejected")
+	}
+}
+
+func TestConnectClientCachesLookups(t *testing.T) {
+	var vaultRequests, itemRequests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/vaults":
+			vaultRequests++
+			json.NewEncoder(w).Encode([]vaultSummary{{ID: "vault-id", Name: "Production Vault"}})
+		case "/v1/vaults/vault-id/items":
+			itemRequests++
+			json.NewEncoder(w).Encode([]itemSummary{{ID: "item-id", Title: "Database Credentials"}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := newConnectClient(connectClientConfig{
+		BaseURL:    server.URL,
+		Token:      "token",
+		HTTPClient: server.Client(),
+		CacheTTL:   time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		vault, err := client.findVaultByName(context.Background(), "Production Vault")
+		if err != nil {
+			t.Fatalf("findVaultByName: %v", err)
+		}
+		if _, err := client.findItemByTitle(context.Background(), vault.ID, "Database Credentials"); err != nil {
+			t.Fatalf("findItemByTitle: %v", err)
+		}
+	}
+
+	if vaultRequests != 1 || itemRequests != 1 {
+		t.Fatalf("expected cached lookups, got %d vault requests and %d item requests", vaultRequests, itemRequests)
+	}
+
+	if hits, misses := client.vaultCache.stats(); hits != 2 || misses != 1 {
+		t.Fatalf("unexpected vault cache stats: hits=%d misses=%d", hits, misses)
+	}
+}
+
+func TestConnectClientRetriesOnServerError(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode([]vaultSummary{{ID: "vault-id", Name: "Production Vault"}})
+	}))
+	defer server.Close()
+
+	client, err := newConnectClient(connectClientConfig{
+		BaseURL:      server.URL,
+		Token:        "token",
+		HTTPClient:   server.Client(),
+		MaxRetries:   3,
+		RetryInitial: time.Millisecond,
+		RetryMax:     10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	if _, err := client.findVaultByName(context.Background(), "Production Vault"); err != nil {
+		t.Fatalf("expected retries to succeed, got: %v", err)
+	}
+	if requests != 3 {
+		t.Fatalf("expected 3 requests, got %d", requests)
+	}
+}
+
+func TestConnectClientGivesUpAfterMaxRetries(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	client, err := newConnectClient(connectClientConfig{
+		BaseURL:      server.URL,
+		Token:        "token",
+		HTTPClient:   server.Client(),
+		MaxRetries:   2,
+		RetryInitial: time.Millisecond,
+		RetryMax:     5 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	_, err = client.findVaultByName(context.Background(), "Production Vault")
+	if err == nil {
+		t.Fatal("expected error after exhausting retries")
+	}
+	var retryable *RetryableError
+	if !errors.As(err, &retryable) {
+		t.Fatalf("expected *RetryableError, got %T: %v", err, err)
+	}
+	if requests != 3 {
+		t.Fatalf("expected 1 initial attempt + 2 retries = 3 requests, got %d", requests)
+	}
+}
+
+func TestConnectClientRequestTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		json.NewEncoder(w).Encode([]vaultSummary{{ID: "vault-id", Name: "Production Vault"}})
+	}))
+	defer server.Close()
+
+	client, err := newConnectClient(connectClientConfig{
+		BaseURL:        server.URL,
+		Token:          "token",
+		HTTPClient:     server.Client(),
+		RequestTimeout: 5 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	_, err = client.findVaultByName(context.Background(), "Production Vault")
+	if err == nil {
+		t.Fatal("expected request to time out")
+	}
+
+	client.SetDeadline(0)
+	if _, err := client.findVaultByName(context.Background(), "Production Vault"); err != nil {
+		t.Fatalf("expected request to succeed once deadline disabled: %v", err)
+	}
+}
+
+func TestSelectFieldValueErrors(t *testing.T) {
+	item := &fullItem{
+		Title: "Sample",
+		Fields: []itemField{
+			{Label: "Username", Value: "octocat"},
+		},
+	}
+
+	if _, err := selectFieldValue(item, ""); !errors.Is(err, ErrFieldNotFound) {
+		t.Fatalf("expected ErrFieldNotFound, got %v", err)
+	}
+
+	if _, err := selectFieldValue(item, "notes"); !errors.Is(err, ErrFieldNotFound) {
+		t.Fatalf("expected ErrFieldNotFound for missing notes, got %v", err)
+	}
+
+	ambiguous := &fullItem{
+		Title: "Sample",
+		Fields: []itemField{
+			{Label: "Password", Value: "one", Purpose: "PASSWORD"},
+			{Label: "Password", Value: "two", Purpose: "PASSWORD"},
+		},
+	}
+	if _, err := selectFieldValue(ambiguous, ""); !errors.Is(err, ErrFieldAmbiguous) {
+		t.Fatalf("expected ErrFieldAmbiguous, got %v", err)
+	}
+}
+
+func TestFindVaultByNameNotFound(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]vaultSummary{})
+	}))
+	defer server.Close()
+
+	client, err := newConnectClient(connectClientConfig{
+		BaseURL:    server.URL,
+		Token:      "token",
+		HTTPClient: server.Client(),
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	_, err = client.findVaultByName(context.Background(), "Missing Vault")
+	if !errors.Is(err, ErrVaultNotFound) {
+		t.Fatalf("expected ErrVaultNotFound, got %v", err)
+	}
+}
+
+func TestConnectClientUnauthorized(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	client, err := newConnectClient(connectClientConfig{
+		BaseURL:    server.URL,
+		Token:      "token",
+		HTTPClient: server.Client(),
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	_, err = client.findVaultByName(context.Background(), "Production Vault")
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected ErrUnauthorized, got %v", err)
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected *APIError in chain, got %v", err)
+	}
+}
+
 func checkQuery(t *testing.T, r *http.Request, want string) {
 	t.Helper()
 	if got := r.URL.Query().Get("filter"); got != want {
 		t.Fatalf("unexpected filter: got %q want %q", got, want)
 	}
 }
+
+func TestDescribeRef(t *testing.T) {
+	vault, item, field := describeRef("op", "Vault/Item/Field")
+	if vault != "Vault" || item != "Item" || field != "Field" {
+		t.Fatalf("unexpected result: %q/%q/%q", vault, item, field)
+	}
+
+	vault, item, field = describeRef("op", "vaults/v-uuid/items/i-uuid")
+	if vault != "v-uuid" || item != "i-uuid" || field != "" {
+		t.Fatalf("unexpected native ref result: %q/%q/%q", vault, item, field)
+	}
+
+	vault, item, field = describeRef("env", "DB_PASSWORD")
+	if vault != "" || item != "" || field != "" {
+		t.Fatalf("expected non-op scheme to be left undescribed, got %q/%q/%q", vault, item, field)
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"not found", ErrVaultNotFound, "not_found"},
+		{"ambiguous", ErrItemAmbiguous, "ambiguous"},
+		{"unauthorized", ErrUnauthorized, "unauthorized"},
+		{"retryable", &RetryableError{Err: errors.New("boom")}, "retryable"},
+		{"other", errors.New("boom"), "other"},
+	}
+	for _, tc := range tests {
+		if got := errorClass(tc.err); got != tc.want {
+			t.Fatalf("%s: got %q want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestPluginFindRecordsMetrics(t *testing.T) {
+	t.Setenv("TEST_METRICS_PASSWORD", "hunter2")
+
+	collectors := metrics.New()
+	plug, err := New(Config{
+		BaseURL:    "https://example.com",
+		Token:      "token",
+		HTTPClient: http.DefaultClient,
+		Logger:     logrus.New(),
+		Metrics:    collectors,
+	})
+	if err != nil {
+		t.Fatalf("failed to create plugin: %v", err)
+	}
+
+	if _, err := plug.Find(context.Background(), &secret.Request{
+		Name: "db_password",
+		Path: "env://TEST_METRICS_PASSWORD",
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := plug.Find(context.Background(), &secret.Request{
+		Name: "db_password",
+		Path: "env://MISSING",
+	}); err == nil {
+		t.Fatal("expected error for unset environment variable")
+	}
+
+	recorder := httptest.NewRecorder()
+	collectors.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := recorder.Body.String()
+
+	if !strings.Contains(body, `drone_1password_secret_requests_total{error_class="",result="success",vault=""} 1`) {
+		t.Fatalf("missing success counter in scrape:\n%s", body)
+	}
+	if !strings.Contains(body, `result="failure"`) {
+		t.Fatalf("missing failure counter in scrape:\n%s", body)
+	}
+}
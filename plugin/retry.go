@@ -0,0 +1,70 @@
+// Copyright 2019 the Drone Authors. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package plugin
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryableError wraps an error known to be transient (an HTTP 429 or 5xx
+// response from 1Password Connect) so callers can distinguish it from a
+// permanent failure with errors.As. RetryAfter, when non-zero, is the
+// server-requested wait derived from a Retry-After header.
+type RetryableError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *RetryableError) Error() string { return e.Err.Error() }
+func (e *RetryableError) Unwrap() error { return e.Err }
+
+// retryPolicy controls the backoff schedule for retrying RetryableErrors.
+type retryPolicy struct {
+	MaxRetries int
+	Initial    time.Duration
+	Max        time.Duration
+}
+
+// nextBackoff returns the delay to wait before the given retry attempt
+// (0-indexed), doubling the initial delay each time up to Max and adding up
+// to 50% jitter so a burst of clients don't retry in lockstep.
+func (p retryPolicy) nextBackoff(attempt int) time.Duration {
+	delay := p.Initial
+	for i := 0; i < attempt; i++ {
+		delay *= 2
+		if delay > p.Max {
+			delay = p.Max
+			break
+		}
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)/2+1))
+}
+
+// parseRetryAfter parses a Retry-After header, which may be either a
+// number of seconds or an HTTP date. It returns 0 if the header is absent
+// or unparseable.
+func parseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if when, err := http.ParseTime(header); err == nil {
+		if wait := time.Until(when); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
@@ -0,0 +1,106 @@
+// Copyright 2019 the Drone Authors. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// TokenSource obtains a bearer token for authenticating to 1Password
+// Connect. Expiry is the time the token stops being valid; a zero Time
+// means the token does not expire (e.g. a static, operator-issued token).
+type TokenSource interface {
+	Token(ctx context.Context) (token string, expiry time.Time, err error)
+}
+
+// tokenRefreshSkew is how far ahead of a token's reported expiry
+// refreshingTokenSource fetches a replacement, so a request in flight
+// doesn't race an about-to-expire token.
+const tokenRefreshSkew = 30 * time.Second
+
+// staticTokenSource returns the same operator-supplied token forever. It
+// backs the original OP_CONNECT_TOKEN behavior.
+type staticTokenSource struct {
+	token string
+}
+
+func (s staticTokenSource) Token(_ context.Context) (string, time.Time, error) {
+	if s.token == "" {
+		return "", time.Time{}, fmt.Errorf("missing 1Password Connect token")
+	}
+	return s.token, time.Time{}, nil
+}
+
+// NewStaticTokenSource returns a TokenSource that always returns the same,
+// operator-supplied token.
+func NewStaticTokenSource(token string) TokenSource {
+	return staticTokenSource{token: token}
+}
+
+// NewK8sTokenSource returns a TokenSource that reads a Kubernetes
+// projected service account token from disk, refreshing it as the kubelet
+// rotates the file. An empty path uses the standard in-pod location.
+func NewK8sTokenSource(path string) TokenSource {
+	return newK8sTokenSource(path)
+}
+
+// NewOIDCTokenSource returns a TokenSource that obtains tokens from a
+// generic OAuth2 client-credentials endpoint (GitHub Actions OIDC, a
+// Kubernetes-issued OIDC provider, or any compliant identity provider).
+func NewOIDCTokenSource(tokenURL, clientID, clientSecret, scope string, httpClient *http.Client) TokenSource {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &oidcTokenSource{
+		tokenURL:     tokenURL,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		scope:        scope,
+		httpClient:   httpClient,
+	}
+}
+
+// refreshingTokenSource caches the token returned by an underlying
+// TokenSource and transparently fetches a new one once it is within
+// tokenRefreshSkew of expiring.
+type refreshingTokenSource struct {
+	source TokenSource
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+func newRefreshingTokenSource(source TokenSource) *refreshingTokenSource {
+	return &refreshingTokenSource{source: source}
+}
+
+func (r *refreshingTokenSource) Token(ctx context.Context) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.token != "" && !r.needsRefresh() {
+		return r.token, nil
+	}
+
+	token, expiry, err := r.source.Token(ctx)
+	if err != nil {
+		return "", err
+	}
+	r.token = token
+	r.expiry = expiry
+	return token, nil
+}
+
+func (r *refreshingTokenSource) needsRefresh() bool {
+	if r.expiry.IsZero() {
+		return false
+	}
+	return time.Now().After(r.expiry.Add(-tokenRefreshSkew))
+}
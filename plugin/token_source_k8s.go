@@ -0,0 +1,78 @@
+// Copyright 2019 the Drone Authors. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package plugin
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultK8sTokenPath is where Kubernetes projects a service account token
+// into a pod by default.
+const defaultK8sTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// k8sTokenSource reads a Kubernetes projected service account token from
+// disk on every call. The kubelet rewrites the file in place well before
+// expiry, so "refreshing" is just re-reading it; the reported expiry comes
+// from the token's own JWT "exp" claim.
+type k8sTokenSource struct {
+	path string
+}
+
+func newK8sTokenSource(path string) *k8sTokenSource {
+	if path == "" {
+		path = defaultK8sTokenPath
+	}
+	return &k8sTokenSource{path: path}
+}
+
+func (s *k8sTokenSource) Token(_ context.Context) (string, time.Time, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("read kubernetes service account token %q: %w", s.path, err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", time.Time{}, fmt.Errorf("kubernetes service account token %q is empty", s.path)
+	}
+
+	expiry, err := jwtExpiry(token)
+	if err != nil {
+		// Not a JWT we can introspect; refresh again soon rather than
+		// treating the token as never expiring.
+		return token, time.Now().Add(time.Minute), nil
+	}
+	return token, expiry, nil
+}
+
+// jwtExpiry extracts the "exp" claim from a JWT without verifying its
+// signature; the token is only ever sent back to its issuer (1Password
+// Connect or the kubelet-managed apiserver), so verification here would be
+// redundant.
+func jwtExpiry(token string) (time.Time, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, fmt.Errorf("not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("decode JWT payload: %w", err)
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return time.Time{}, fmt.Errorf("decode JWT claims: %w", err)
+	}
+	if claims.Exp == 0 {
+		return time.Time{}, fmt.Errorf("JWT has no exp claim")
+	}
+	return time.Unix(claims.Exp, 0), nil
+}
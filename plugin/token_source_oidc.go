@@ -0,0 +1,70 @@
+// Copyright 2019 the Drone Authors. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// oidcTokenSource obtains a Connect token from a generic OAuth2
+// client-credentials endpoint, as exposed by most OIDC identity providers.
+type oidcTokenSource struct {
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	scope        string
+	httpClient   *http.Client
+}
+
+func (s *oidcTokenSource) Token(ctx context.Context) (string, time.Time, error) {
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {s.clientID},
+		"client_secret": {s.clientSecret},
+	}
+	if s.scope != "" {
+		form.Set("scope", s.scope)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", time.Time{}, fmt.Errorf("oidc token endpoint %s returned %s", s.tokenURL, resp.Status)
+	}
+
+	var payload struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		return "", time.Time{}, fmt.Errorf("decode oidc token response: %w", err)
+	}
+	if payload.AccessToken == "" {
+		return "", time.Time{}, fmt.Errorf("oidc token endpoint %s did not return an access_token", s.tokenURL)
+	}
+
+	var expiry time.Time
+	if payload.ExpiresIn > 0 {
+		expiry = time.Now().Add(time.Duration(payload.ExpiresIn) * time.Second)
+	}
+	return payload.AccessToken, expiry, nil
+}